@@ -0,0 +1,36 @@
+// Package storage defines the database access contracts used by the
+// unitofwork and outbox packages, independent of the specific driver
+// (pgx, database/sql) that backs them.
+package storage
+
+import (
+	"context"
+	"database/sql"
+)
+
+// DB opens transactions against the underlying connection pool.
+type DB interface {
+	Begin(ctx context.Context) (DBContext, error)
+}
+
+// DBContext is a single database transaction. It exposes ExecContext and
+// QueryContext directly, so it satisfies outbox.Querier and can be handed
+// to an outbox.Store without adapting it first.
+type DBContext interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+
+	// Rollback aborts the transaction.
+	Rollback() error
+
+	// Savepoint marks a point within the transaction that RollbackTo can
+	// later undo back to without aborting the whole transaction. It backs
+	// unitofwork.Atomic's support for nested calls.
+	Savepoint(name string) error
+	// RollbackTo undoes everything done since the named Savepoint, without
+	// affecting the rest of the transaction.
+	RollbackTo(name string) error
+	// Release discards the named Savepoint once the work it guarded has
+	// completed successfully; it does not commit the transaction itself.
+	Release(name string) error
+}
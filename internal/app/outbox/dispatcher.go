@@ -0,0 +1,238 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/burenotti/go_health_backend/internal/domain"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MessageBus is the subset of the messaging contract the dispatcher needs:
+// publishing decoded events. The dispatcher is the only part of the
+// application that publishes outbox events.
+type MessageBus interface {
+	PublishEvents(events ...domain.Event) error
+}
+
+// DB is the connection pool the dispatcher polls against directly, outside
+// of any business transaction.
+type DB interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+const (
+	defaultMaxAttempts = 5
+	defaultBackoffBase = time.Second
+	defaultBackoffMax  = 5 * time.Minute
+)
+
+// DispatcherOption configures an OutboxDispatcher.
+type DispatcherOption func(*OutboxDispatcher)
+
+// WithPollInterval overrides the default polling interval between batches.
+func WithPollInterval(d time.Duration) DispatcherOption {
+	return func(o *OutboxDispatcher) { o.pollInterval = d }
+}
+
+// WithMaxAttempts overrides how many delivery attempts are made before a
+// record is moved to the dead letter state.
+func WithMaxAttempts(n int) DispatcherOption {
+	return func(o *OutboxDispatcher) { o.maxAttempts = n }
+}
+
+// WithBatchSize overrides how many records are claimed per poll.
+func WithBatchSize(n int) DispatcherOption {
+	return func(o *OutboxDispatcher) { o.batchSize = n }
+}
+
+// WithBackoff overrides the exponential backoff applied between delivery
+// attempts of a record that failed: base doubles each attempt, capped at
+// max. A record is not reconsidered by dispatchBatch's poll until its
+// backoff elapses.
+func WithBackoff(base, max time.Duration) DispatcherOption {
+	return func(o *OutboxDispatcher) {
+		o.backoffBase = base
+		o.backoffMax = max
+	}
+}
+
+// OutboxDispatcher polls the outbox table for undelivered records and
+// publishes them to the MessageBus, retrying with exponential backoff and
+// dead-lettering records that exceed maxAttempts.
+type OutboxDispatcher struct {
+	db           DB
+	msgBus       MessageBus
+	registry     *Registry
+	logger       *slog.Logger
+	pollInterval time.Duration
+	maxAttempts  int
+	batchSize    int
+	backoffBase  time.Duration
+	backoffMax   time.Duration
+	tracer       trace.Tracer
+	metrics      *dispatcherMetrics
+}
+
+// NewOutboxDispatcher builds a dispatcher that decodes claimed records
+// through registry before publishing them. registry must have a decoder
+// registered for every event type the outbox can contain, or delivery of
+// that type will fail and eventually dead-letter.
+func NewOutboxDispatcher(db DB, msgBus MessageBus, registry *Registry, logger *slog.Logger, opts ...DispatcherOption) *OutboxDispatcher {
+	d := &OutboxDispatcher{
+		db:           db,
+		msgBus:       msgBus,
+		registry:     registry,
+		logger:       logger,
+		pollInterval: time.Second,
+		maxAttempts:  defaultMaxAttempts,
+		batchSize:    100,
+		backoffBase:  defaultBackoffBase,
+		backoffMax:   defaultBackoffMax,
+		tracer:       defaultTracer(),
+	}
+	d.setMeter(defaultMeter())
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Run polls the outbox until ctx is cancelled. It is meant to be launched
+// in its own goroutine by the caller.
+func (d *OutboxDispatcher) Run(ctx context.Context) error {
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := d.dispatchBatch(ctx); err != nil {
+				d.logger.Error("outbox: failed to dispatch batch", "error", err)
+			}
+		}
+	}
+}
+
+func (d *OutboxDispatcher) dispatchBatch(ctx context.Context) error {
+	start := time.Now()
+	ctx, span := d.tracer.Start(ctx, "outbox.DispatchBatch")
+	var claimedCount int
+	defer func() {
+		span.SetAttributes(attribute.Int("batch.size", claimedCount))
+		span.End()
+		d.metrics.recordBatch(ctx, claimedCount, time.Since(start))
+	}()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		`SELECT id, event_type, payload, attempts
+		   FROM outbox
+		  WHERE delivered_at IS NULL AND dead_lettered_at IS NULL AND next_attempt_at <= now()
+		  ORDER BY next_attempt_at
+		  FOR UPDATE SKIP LOCKED
+		  LIMIT $1`,
+		d.batchSize,
+	)
+	if err != nil {
+		return err
+	}
+
+	var claimed []Record
+	for rows.Next() {
+		var r Record
+		if err := rows.Scan(&r.ID, &r.EventType, &r.Payload, &r.Attempts); err != nil {
+			rows.Close()
+			return err
+		}
+		claimed = append(claimed, r)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+	claimedCount = len(claimed)
+
+	for _, r := range claimed {
+		d.deliver(ctx, tx, r)
+	}
+
+	return tx.Commit()
+}
+
+// deliver attempts to publish a single claimed record and updates its
+// delivery state in the same transaction that claimed it. A decode or
+// publish error backs off the record, per d.backoffBase/backoffMax, rather
+// than failing the whole batch, so one bad event can't block the others.
+func (d *OutboxDispatcher) deliver(ctx context.Context, q Querier, r Record) {
+	ctx, span := d.tracer.Start(ctx, "outbox.Deliver", trace.WithAttributes(
+		attribute.String("event_type", r.EventType),
+	))
+	defer span.End()
+
+	event, err := d.registry.Decode(r.EventType, r.Payload)
+	if err != nil {
+		d.failDelivery(ctx, q, r, "decode", err)
+		return
+	}
+
+	if err := d.msgBus.PublishEvents(event); err != nil {
+		d.failDelivery(ctx, q, r, "publish", err)
+		return
+	}
+
+	if _, err := q.ExecContext(ctx, `UPDATE outbox SET delivered_at = now() WHERE id = $1`, r.ID); err != nil {
+		d.logger.Error("outbox: failed to mark record delivered", "id", r.ID, "error", err)
+		return
+	}
+	d.metrics.recordDelivered(ctx, r.EventType)
+}
+
+// failDelivery records a failed delivery attempt, whether caused by a
+// decode error or a publish error (reason), and dead-letters the record
+// once it has exhausted maxAttempts. Otherwise it pushes the record's
+// next_attempt_at out by the backoff due at this attempt count, so
+// dispatchBatch's poll query skips it until the backoff elapses.
+func (d *OutboxDispatcher) failDelivery(ctx context.Context, q Querier, r Record, reason string, cause error) {
+	d.metrics.recordFailed(ctx, r.EventType, reason)
+
+	attempts := r.Attempts + 1
+	dead := attempts >= d.maxAttempts
+
+	query := `UPDATE outbox SET attempts = $1, last_error = $2, next_attempt_at = $3 WHERE id = $4`
+	if dead {
+		d.logger.Error("outbox: record exceeded max attempts, dead-lettering",
+			"id", r.ID, "event_type", r.EventType, "attempts", attempts)
+		query = `UPDATE outbox SET attempts = $1, last_error = $2, next_attempt_at = $3, dead_lettered_at = now() WHERE id = $4`
+		d.metrics.recordDeadLettered(ctx, r.EventType)
+	}
+	nextAttemptAt := time.Now().Add(d.backoffDelay(attempts))
+	if _, updErr := q.ExecContext(ctx, query, attempts, cause.Error(), nextAttemptAt, r.ID); updErr != nil {
+		d.logger.Error("outbox: failed to record delivery failure", "id", r.ID, "error", updErr)
+	}
+}
+
+// backoffDelay returns the backoff before the given attempt count,
+// doubling backoffBase each time and capping at backoffMax.
+func (d *OutboxDispatcher) backoffDelay(attempts int) time.Duration {
+	if d.backoffBase <= 0 {
+		return 0
+	}
+	delay := d.backoffBase << uint(attempts-1)
+	if d.backoffMax > 0 && delay > d.backoffMax {
+		return d.backoffMax
+	}
+	return delay
+}
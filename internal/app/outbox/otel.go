@@ -0,0 +1,111 @@
+package outbox
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/burenotti/go_health_backend/internal/app/outbox"
+
+// WithTracerProvider overrides the trace.TracerProvider dispatch batches
+// are traced with. Defaults to the global provider.
+func WithTracerProvider(tp trace.TracerProvider) DispatcherOption {
+	return func(d *OutboxDispatcher) { d.tracer = tp.Tracer(instrumentationName) }
+}
+
+// WithMeterProvider overrides the metric.MeterProvider delivery metrics
+// are recorded with. Defaults to the global provider.
+func WithMeterProvider(mp metric.MeterProvider) DispatcherOption {
+	return func(d *OutboxDispatcher) { d.setMeter(mp.Meter(instrumentationName)) }
+}
+
+// dispatcherMetrics holds the Prometheus-exported instruments for an
+// OutboxDispatcher. A nil instrument (created when registration failed) is
+// skipped rather than causing a panic.
+type dispatcherMetrics struct {
+	delivered     metric.Int64Counter
+	failed        metric.Int64Counter
+	deadLettered  metric.Int64Counter
+	batchDuration metric.Float64Histogram
+}
+
+func (d *OutboxDispatcher) setMeter(meter metric.Meter) {
+	m := &dispatcherMetrics{}
+
+	var err error
+	if m.delivered, err = meter.Int64Counter(
+		"outbox_delivered_total",
+		metric.WithDescription("Outbox records successfully published to the message bus"),
+	); err != nil {
+		d.logger.Error("failed to create outbox_delivered_total metric", "error", err)
+	}
+	if m.failed, err = meter.Int64Counter(
+		"outbox_delivery_failed_total",
+		metric.WithDescription("Outbox records that failed a delivery attempt, by reason"),
+	); err != nil {
+		d.logger.Error("failed to create outbox_delivery_failed_total metric", "error", err)
+	}
+	if m.deadLettered, err = meter.Int64Counter(
+		"outbox_dead_lettered_total",
+		metric.WithDescription("Outbox records dead-lettered after exhausting max attempts"),
+	); err != nil {
+		d.logger.Error("failed to create outbox_dead_lettered_total metric", "error", err)
+	}
+	if m.batchDuration, err = meter.Float64Histogram(
+		"outbox_dispatch_batch_duration_seconds",
+		metric.WithDescription("Duration of a single outbox dispatch batch"),
+		metric.WithUnit("s"),
+	); err != nil {
+		d.logger.Error("failed to create outbox_dispatch_batch_duration_seconds metric", "error", err)
+	}
+
+	d.metrics = m
+}
+
+func (m *dispatcherMetrics) recordBatch(ctx context.Context, claimed int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	if m.batchDuration != nil {
+		m.batchDuration.Record(ctx, duration.Seconds(), metric.WithAttributes(
+			attribute.Int("batch.size", claimed),
+		))
+	}
+}
+
+func (m *dispatcherMetrics) recordDelivered(ctx context.Context, eventType string) {
+	if m == nil || m.delivered == nil {
+		return
+	}
+	m.delivered.Add(ctx, 1, metric.WithAttributes(attribute.String("event_type", eventType)))
+}
+
+func (m *dispatcherMetrics) recordFailed(ctx context.Context, eventType, reason string) {
+	if m == nil || m.failed == nil {
+		return
+	}
+	m.failed.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("event_type", eventType),
+		attribute.String("reason", reason),
+	))
+}
+
+func (m *dispatcherMetrics) recordDeadLettered(ctx context.Context, eventType string) {
+	if m == nil || m.deadLettered == nil {
+		return
+	}
+	m.deadLettered.Add(ctx, 1, metric.WithAttributes(attribute.String("event_type", eventType)))
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+func defaultMeter() metric.Meter {
+	return otel.Meter(instrumentationName)
+}
@@ -0,0 +1,128 @@
+// Package outbox implements the transactional outbox pattern: domain events
+// are written to an outbox table in the same DB transaction as the business
+// data that produced them, and are later delivered to the message bus by a
+// separate OutboxDispatcher. This avoids losing events when a transaction
+// commits but the subsequent publish call fails.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/burenotti/go_health_backend/internal/domain"
+)
+
+var (
+	ErrNotSerializable  = errors.New("outbox: event does not implement EventSerializer")
+	ErrUnknownEventType = errors.New("outbox: no decoder registered for event type")
+)
+
+// EventSerializer is implemented by domain.Event values that can be
+// persisted to the outbox. Events that don't implement it cannot be
+// stored transactionally and are rejected by Store.Save.
+type EventSerializer interface {
+	domain.Event
+	EventType() string
+	Serialize() ([]byte, error)
+}
+
+// Record is a single row of the outbox table.
+type Record struct {
+	ID             int64
+	EventType      string
+	Payload        []byte
+	CreatedAt      time.Time
+	DeliveredAt    sql.NullTime
+	DeadLetteredAt sql.NullTime
+	Attempts       int
+	LastError      sql.NullString
+	// NextAttemptAt is when this record next becomes eligible for delivery.
+	// It starts at CreatedAt and is pushed out by OutboxDispatcher.failDelivery
+	// after each failed attempt, per the dispatcher's backoff policy.
+	NextAttemptAt time.Time
+}
+
+// Querier is the subset of *sql.Tx / *sql.DB used by the outbox. It is
+// satisfied by storage.DBContext, so no changes to the storage package are
+// required to adopt it inside a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// EventDecoder reconstructs the concrete domain.Event an EventSerializer
+// serialized, given the raw bytes Store.Save wrote to the outbox table.
+type EventDecoder func(payload []byte) (domain.Event, error)
+
+// Registry maps an event type, as returned by EventSerializer.EventType,
+// to the EventDecoder that can reconstruct it. OutboxDispatcher uses it to
+// turn a stored record's raw payload back into a concrete domain.Event
+// before publishing, since a Record only carries bytes, not a type the
+// dispatcher could otherwise construct generically.
+type Registry struct {
+	decoders map[string]EventDecoder
+}
+
+func NewRegistry() *Registry {
+	return &Registry{decoders: make(map[string]EventDecoder)}
+}
+
+// Register adds decode as the decoder for eventType. It panics on a
+// duplicate registration, since that is a programming error that should
+// fail at startup rather than silently shadow the first decoder.
+func (r *Registry) Register(eventType string, decode EventDecoder) {
+	if _, exists := r.decoders[eventType]; exists {
+		panic(fmt.Sprintf("outbox: decoder already registered for event type %q", eventType))
+	}
+	r.decoders[eventType] = decode
+}
+
+// Decode reconstructs the event stored under eventType, or
+// ErrUnknownEventType if no decoder was registered for it.
+func (r *Registry) Decode(eventType string, payload []byte) (domain.Event, error) {
+	decode, ok := r.decoders[eventType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownEventType, eventType)
+	}
+	return decode(payload)
+}
+
+// Store persists and retrieves outbox records.
+type Store struct {
+	q Querier
+}
+
+func NewStore(q Querier) *Store {
+	return &Store{q: q}
+}
+
+// Save writes events to the outbox table. It must be called with a Querier
+// that is part of the same transaction as the business writes that
+// produced the events, so that a rollback discards both together.
+func (s *Store) Save(ctx context.Context, events ...domain.Event) error {
+	for _, event := range events {
+		serializable, ok := event.(EventSerializer)
+		if !ok {
+			return fmt.Errorf("%w: %T", ErrNotSerializable, event)
+		}
+
+		payload, err := serializable.Serialize()
+		if err != nil {
+			return fmt.Errorf("serialize event %s: %w", serializable.EventType(), err)
+		}
+
+		_, err = s.q.ExecContext(
+			ctx,
+			`INSERT INTO outbox (event_type, payload, created_at) VALUES ($1, $2, now())`,
+			serializable.EventType(),
+			payload,
+		)
+		if err != nil {
+			return fmt.Errorf("insert outbox record: %w", err)
+		}
+	}
+	return nil
+}
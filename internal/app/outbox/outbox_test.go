@@ -0,0 +1,193 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/burenotti/go_health_backend/internal/domain"
+)
+
+// fakeResult is a no-op sql.Result for the ExecContext calls the fakes below
+// make instead of talking to a real database.
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (fakeResult) RowsAffected() (int64, error) { return 0, nil }
+
+type execCall struct {
+	query string
+	args  []any
+}
+
+// fakeQuerier is an in-memory Querier that records what was executed on it
+// instead of talking to a real database.
+type fakeQuerier struct {
+	execErr error
+	calls   []execCall
+}
+
+func (q *fakeQuerier) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	q.calls = append(q.calls, execCall{query: query, args: args})
+	if q.execErr != nil {
+		return nil, q.execErr
+	}
+	return fakeResult{}, nil
+}
+
+func (q *fakeQuerier) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, nil
+}
+
+// fakeMessageBus is a MessageBus that records what was published, or fails
+// every publish if err is set.
+type fakeMessageBus struct {
+	err       error
+	published []domain.Event
+}
+
+func (b *fakeMessageBus) PublishEvents(events ...domain.Event) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.published = append(b.published, events...)
+	return nil
+}
+
+// fakeEvent is a domain.Event that also satisfies EventSerializer.
+type fakeEvent struct{ eventType string }
+
+func (e fakeEvent) EventType() string          { return e.eventType }
+func (e fakeEvent) Serialize() ([]byte, error) { return []byte(e.eventType), nil }
+
+// nonSerializableEvent is a domain.Event that doesn't implement
+// EventSerializer, to exercise Store.Save's rejection path.
+type nonSerializableEvent struct{}
+
+func (nonSerializableEvent) EventType() string { return "non.serializable" }
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+func TestStore_Save_PersistsSerializableEvents(t *testing.T) {
+	q := &fakeQuerier{}
+	store := NewStore(q)
+
+	err := store.Save(context.Background(), fakeEvent{eventType: "thing.happened"})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(q.calls) != 1 {
+		t.Fatalf("expected 1 insert, got %d", len(q.calls))
+	}
+}
+
+func TestStore_Save_RejectsNonSerializableEvents(t *testing.T) {
+	store := NewStore(&fakeQuerier{})
+
+	err := store.Save(context.Background(), nonSerializableEvent{})
+
+	if !errors.Is(err, ErrNotSerializable) {
+		t.Fatalf("expected ErrNotSerializable, got %v", err)
+	}
+}
+
+func TestRegistry_DecodeUnknownEventType(t *testing.T) {
+	r := NewRegistry()
+
+	_, err := r.Decode("nope", nil)
+
+	if !errors.Is(err, ErrUnknownEventType) {
+		t.Fatalf("expected ErrUnknownEventType, got %v", err)
+	}
+}
+
+func TestRegistry_RegisterAndDecode(t *testing.T) {
+	r := NewRegistry()
+	r.Register("thing.happened", func(payload []byte) (domain.Event, error) {
+		return fakeEvent{eventType: string(payload)}, nil
+	})
+
+	event, err := r.Decode("thing.happened", []byte("thing.happened"))
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, ok := event.(fakeEvent)
+	if !ok || got.eventType != "thing.happened" {
+		t.Fatalf("got %#v, want fakeEvent{thing.happened}", event)
+	}
+}
+
+func TestRegistry_RegisterPanicsOnDuplicate(t *testing.T) {
+	r := NewRegistry()
+	decode := func(payload []byte) (domain.Event, error) { return fakeEvent{}, nil }
+	r.Register("thing.happened", decode)
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic on duplicate registration")
+		}
+	}()
+	r.Register("thing.happened", decode)
+}
+
+func TestDeliver_PublishesAndMarksDelivered(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register("thing.happened", func(payload []byte) (domain.Event, error) {
+		return fakeEvent{eventType: string(payload)}, nil
+	})
+	bus := &fakeMessageBus{}
+	d := NewOutboxDispatcher(nil, bus, registry, testLogger())
+
+	q := &fakeQuerier{}
+	d.deliver(context.Background(), q, Record{ID: 1, EventType: "thing.happened", Payload: []byte("thing.happened")})
+
+	if len(bus.published) != 1 {
+		t.Fatalf("expected 1 published event, got %d", len(bus.published))
+	}
+	if len(q.calls) != 1 || !strings.Contains(q.calls[0].query, "delivered_at") {
+		t.Fatalf("expected a delivered_at update, got calls: %v", q.calls)
+	}
+}
+
+func TestDeliver_BacksOffBeforeMaxAttempts(t *testing.T) {
+	registry := NewRegistry()
+	bus := &fakeMessageBus{err: errors.New("publish failed")}
+	d := NewOutboxDispatcher(nil, bus, registry, testLogger(), WithMaxAttempts(5))
+
+	q := &fakeQuerier{}
+	d.deliver(context.Background(), q, Record{ID: 1, EventType: "thing.happened", Attempts: 0})
+
+	if len(q.calls) != 1 {
+		t.Fatalf("expected 1 update, got %d", len(q.calls))
+	}
+	if strings.Contains(q.calls[0].query, "dead_lettered_at") {
+		t.Fatalf("expected no dead-letter update before max attempts, got query: %s", q.calls[0].query)
+	}
+	if !strings.Contains(q.calls[0].query, "next_attempt_at") {
+		t.Fatalf("expected the update to reschedule next_attempt_at, got query: %s", q.calls[0].query)
+	}
+}
+
+func TestDeliver_DeadLettersAfterMaxAttempts(t *testing.T) {
+	registry := NewRegistry()
+	bus := &fakeMessageBus{err: errors.New("publish failed")}
+	d := NewOutboxDispatcher(nil, bus, registry, testLogger(), WithMaxAttempts(2))
+
+	q := &fakeQuerier{}
+	d.deliver(context.Background(), q, Record{ID: 1, EventType: "thing.happened", Attempts: 1})
+
+	if len(q.calls) != 1 {
+		t.Fatalf("expected 1 update, got %d", len(q.calls))
+	}
+	if !strings.Contains(q.calls[0].query, "dead_lettered_at") {
+		t.Fatalf("expected the dead-letter update, got query: %s", q.calls[0].query)
+	}
+}
@@ -0,0 +1,111 @@
+package unitofwork
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/burenotti/go_health_backend/internal/app/unitofwork"
+
+// WithTracerProvider overrides the trace.TracerProvider Atomic calls are
+// traced with. Defaults to the global provider.
+func WithTracerProvider[T AtomicContext](tp trace.TracerProvider) Option[T] {
+	return func(uow *UnitOfWork[T]) {
+		uow.tracer = tp.Tracer(instrumentationName)
+	}
+}
+
+// WithMeterProvider overrides the metric.MeterProvider transaction metrics
+// are recorded with. Defaults to the global provider.
+func WithMeterProvider[T AtomicContext](mp metric.MeterProvider) Option[T] {
+	return func(uow *UnitOfWork[T]) {
+		uow.setMeter(mp.Meter(instrumentationName))
+	}
+}
+
+// uowMetrics holds the Prometheus-exported instruments for a UnitOfWork. A
+// nil instrument (created when registration failed) is skipped rather than
+// causing a panic, so a meter provider misconfiguration doesn't take down
+// transactions.
+type uowMetrics struct {
+	transactions    metric.Int64Counter
+	duration        metric.Float64Histogram
+	eventsPublished metric.Int64Counter
+	retries         metric.Int64Counter
+}
+
+func (uow *UnitOfWork[T]) setMeter(meter metric.Meter) {
+	m := &uowMetrics{}
+
+	var err error
+	if m.transactions, err = meter.Int64Counter(
+		"uow_transactions_total",
+		metric.WithDescription("Unit-of-work transactions by outcome"),
+	); err != nil {
+		uow.logger.Error("failed to create uow_transactions_total metric", "error", err)
+	}
+	if m.duration, err = meter.Float64Histogram(
+		"uow_duration_seconds",
+		metric.WithDescription("Duration of unit-of-work transactions"),
+		metric.WithUnit("s"),
+	); err != nil {
+		uow.logger.Error("failed to create uow_duration_seconds metric", "error", err)
+	}
+	if m.eventsPublished, err = meter.Int64Counter(
+		"uow_events_published_total",
+		metric.WithDescription("Domain events handed off for publishing by committed transactions"),
+	); err != nil {
+		uow.logger.Error("failed to create uow_events_published_total metric", "error", err)
+	}
+	if m.retries, err = meter.Int64Counter(
+		"uow_retry_total",
+		metric.WithDescription("Transaction retries triggered by transient errors"),
+	); err != nil {
+		uow.logger.Error("failed to create uow_retry_total metric", "error", err)
+	}
+
+	uow.metrics = m
+}
+
+func (m *uowMetrics) recordTransaction(ctx context.Context, result string, nested bool, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	attrs := metric.WithAttributes(
+		attribute.String("result", result),
+		attribute.Bool("nested", nested),
+	)
+	if m.transactions != nil {
+		m.transactions.Add(ctx, 1, attrs)
+	}
+	if m.duration != nil {
+		m.duration.Record(ctx, duration.Seconds(), attrs)
+	}
+}
+
+func (m *uowMetrics) recordEventsPublished(ctx context.Context, n int) {
+	if m == nil || m.eventsPublished == nil || n == 0 {
+		return
+	}
+	m.eventsPublished.Add(ctx, int64(n))
+}
+
+func (m *uowMetrics) recordRetry(ctx context.Context) {
+	if m == nil || m.retries == nil {
+		return
+	}
+	m.retries.Add(ctx, 1)
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+func defaultMeter() metric.Meter {
+	return otel.Meter(instrumentationName)
+}
@@ -0,0 +1,89 @@
+package unitofwork
+
+import (
+	"errors"
+	"time"
+)
+
+// Postgres SQLSTATE codes worth retrying: serialization_failure and
+// deadlock_detected are both transient conflicts between concurrent
+// transactions, not programming errors.
+const (
+	sqlStateSerializationFailure = "40001"
+	sqlStateDeadlockDetected     = "40P01"
+)
+
+// sqlStater is implemented by pgx's pgconn.PgError. It's declared locally,
+// rather than imported, so this package doesn't need to depend on the
+// driver just to classify an error.
+type sqlStater interface {
+	SQLState() string
+}
+
+func isTransientError(err error) bool {
+	var pgErr sqlStater
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	switch pgErr.SQLState() {
+	case sqlStateSerializationFailure, sqlStateDeadlockDetected:
+		return true
+	default:
+		return false
+	}
+}
+
+// RetryPolicy configures automatic retry of the Atomic closure on
+// transient errors. MaxAttempts <= 1 disables retrying.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var noRetry = RetryPolicy{MaxAttempts: 1}
+
+// delay returns the backoff to wait after the given failed attempt
+// (1-indexed) before the next one, doubling BaseDelay each time and capping
+// at MaxDelay.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	d := p.BaseDelay << uint(attempt-1)
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// Option configures a UnitOfWork at construction time.
+type Option[T AtomicContext] func(*UnitOfWork[T])
+
+// WithRetry enables automatic retry of the Atomic closure when it fails
+// with a transient error (Postgres serialization failure or deadlock).
+// Retries only apply to the outermost Atomic call in a nesting chain.
+func WithRetry[T AtomicContext](policy RetryPolicy) Option[T] {
+	return func(uow *UnitOfWork[T]) {
+		uow.retry = policy
+	}
+}
+
+// WithIgnoredErrors marks errors that should not roll back the
+// transaction: if do returns one of these (per errors.Is), Atomic still
+// commits and publishes events, then returns the original error to the
+// caller.
+func WithIgnoredErrors[T AtomicContext](errs ...error) Option[T] {
+	return func(uow *UnitOfWork[T]) {
+		uow.ignoredErrors = append(uow.ignoredErrors, errs...)
+	}
+}
+
+func (uow *UnitOfWork[T]) isIgnored(err error) bool {
+	for _, ignored := range uow.ignoredErrors {
+		if errors.Is(err, ignored) {
+			return true
+		}
+	}
+	return false
+}
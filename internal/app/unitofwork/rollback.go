@@ -0,0 +1,34 @@
+package unitofwork
+
+import (
+	"context"
+	"errors"
+)
+
+// Rollbacker is a compensating action for a non-DB side effect (an S3
+// upload, a third-party API call, a filesystem write) that must be undone
+// if the surrounding transaction rolls back.
+type Rollbacker interface {
+	Rollback(ctx context.Context) error
+}
+
+// RollbackFunc adapts a plain function to Rollbacker.
+type RollbackFunc func(ctx context.Context) error
+
+func (f RollbackFunc) Rollback(ctx context.Context) error {
+	return f(ctx)
+}
+
+// runRollbacks invokes hooks in LIFO order, so the most recently performed
+// side effect is undone first, and joins their errors into one. Callers
+// gather hooks themselves, since a rollback may need to run hooks merged
+// from several nested AtomicContexts, not just one.
+func runRollbacks(ctx context.Context, hooks []Rollbacker) error {
+	var errs []error
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].Rollback(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
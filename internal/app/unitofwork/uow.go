@@ -5,8 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/burenotti/go_health_backend/internal/adapter/storage"
+	"github.com/burenotti/go_health_backend/internal/app/outbox"
 	"github.com/burenotti/go_health_backend/internal/domain"
 	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -17,74 +22,358 @@ type AtomicContext interface {
 	Commit() error
 	Close() error
 	CollectEvents() []domain.Event
+
+	// OnRollback registers a compensating action to run, in LIFO order,
+	// if the transaction this AtomicContext belongs to rolls back.
+	OnRollback(r Rollbacker)
+	// Rollbacks returns everything registered via OnRollback so far.
+	Rollbacks() []Rollbacker
+}
+
+// txKey is the context.Context key under which the active transaction
+// state is stored, so that a nested uow.Atomic call can detect it and
+// reuse the outer transaction instead of opening a second one.
+type txKey struct{}
+
+// txState tracks the transaction shared by a chain of nested Atomic calls.
+// depth names each savepoint uniquely. events and rollbacks accumulate
+// everything collected by inner calls that committed successfully: events
+// so they can be persisted and published exactly once, on the outermost
+// commit, and rollbacks so that a later full ROLLBACK of the outermost
+// transaction — which discards every savepoint's work regardless of
+// whether that savepoint was itself released — still runs the
+// compensating actions those inner calls registered.
+type txState struct {
+	tx        storage.DBContext
+	depth     int
+	events    []domain.Event
+	rollbacks []Rollbacker
 }
 
-type MessageBus interface {
-	PublishEvents(events ...domain.Event) error
+func txFromContext(ctx context.Context) (*txState, bool) {
+	state, ok := ctx.Value(txKey{}).(*txState)
+	return state, ok
 }
 
+// UnitOfWork's job ends once events are durably persisted to the outbox
+// within the committed transaction; actually delivering them to a message
+// bus, with its own retry and dead-letter handling, is OutboxDispatcher's
+// job (see internal/app/outbox), so UnitOfWork has no message bus
+// dependency of its own.
 type UnitOfWork[T AtomicContext] struct {
-	db         storage.DB
-	newContext func(storage.DBContext) (T, error)
-	msgBus     MessageBus
-	logger     *slog.Logger
+	db            storage.DB
+	newContext    func(storage.DBContext) (T, error)
+	logger        *slog.Logger
+	retry         RetryPolicy
+	ignoredErrors []error
+	tracer        trace.Tracer
+	metrics       *uowMetrics
 }
 
 func New[T AtomicContext](
 	db storage.DB,
 	newCtx func(storage.DBContext) (T, error),
-	msgBus MessageBus,
 	logger *slog.Logger,
+	opts ...Option[T],
 ) *UnitOfWork[T] {
-	return &UnitOfWork[T]{
+	uow := &UnitOfWork[T]{
 		db:         db,
 		newContext: newCtx,
-		msgBus:     msgBus,
 		logger:     logger,
+		retry:      noRetry,
+		tracer:     defaultTracer(),
 	}
+	uow.setMeter(defaultMeter())
+	for _, opt := range opts {
+		opt(uow)
+	}
+	return uow
+}
+
+// outboxStore persists events to the outbox table as part of the current
+// transaction. storage.DBContext satisfies outbox.Querier, so tx can be
+// passed in directly rather than threading a separate dependency through
+// New.
+func (uow *UnitOfWork[T]) outboxStore(tx outbox.Querier) *outbox.Store {
+	return outbox.NewStore(tx)
 }
 
+// Atomic runs do inside a transaction. If ctx already carries an active
+// transaction started by an outer Atomic call, it is reused: do runs inside
+// a SAVEPOINT so that an inner failure only undoes the inner work, and any
+// events and rollback hooks it collects are merged into the outer call's,
+// to be persisted/published once on the outermost commit, or run in full
+// if the outermost transaction ultimately rolls back instead.
 func (uow *UnitOfWork[T]) Atomic(
 	ctx context.Context,
 	do func(context.Context, T) error,
 ) (err error) {
+	if state, ok := txFromContext(ctx); ok {
+		return uow.atomicNested(ctx, state, do)
+	}
+	return uow.atomicRoot(ctx, do)
+}
+
+// atomicRoot retries runRoot according to uow.retry: only transient errors
+// (serialization failures, deadlocks) trigger a retry, each attempt opens a
+// brand new transaction, and a cancelled ctx is never retried.
+func (uow *UnitOfWork[T]) atomicRoot(
+	ctx context.Context,
+	do func(context.Context, T) error,
+) error {
+	maxAttempts := uow.retry.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = uow.runRoot(ctx, do, attempt)
+		if err == nil || uow.isIgnored(err) {
+			return err
+		}
+		if attempt == maxAttempts || errors.Is(err, context.Canceled) || ctx.Err() != nil || !isTransientError(err) {
+			return err
+		}
+
+		delay := uow.retry.delay(attempt)
+		uow.metrics.recordRetry(ctx)
+		uow.logger.Warn("retrying transaction after transient error",
+			"attempt", attempt, "delay", delay, "error", err)
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return errors.Join(err, ctx.Err())
+			case <-timer.C:
+			}
+		}
+	}
+	return err
+}
+
+func (uow *UnitOfWork[T]) runRoot(
+	ctx context.Context,
+	do func(context.Context, T) error,
+	attempt int,
+) (err error) {
+	start := time.Now()
+	ctx, span := uow.tracer.Start(ctx, "unitofwork.Atomic", trace.WithAttributes(
+		attribute.Int("attempt", attempt),
+		attribute.String("db.system", "postgresql"),
+	))
+	result := "commit"
+	defer func() {
+		span.SetAttributes(attribute.String("result", result))
+		span.End()
+		uow.metrics.recordTransaction(ctx, result, false, time.Since(start))
+	}()
+
 	tx, err := uow.db.Begin(ctx)
 	if err != nil {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", err.Error()))
 		return stateRollbackError(err)
 	}
 
 	atomicCtx, err := uow.newContext(tx)
 	if err != nil {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", err.Error()))
 		return stateRollbackError(err)
 	}
 
+	defer func() {
+		if closeErr := atomicCtx.Close(); closeErr != nil {
+			uow.logger.Error("failed to close transaction context", "error", closeErr)
+		}
+	}()
+
+	state := &txState{tx: tx}
+
 	defer func() {
 		if r := recover(); r != nil {
-			if err := tx.Rollback(); err != nil {
-				uow.logger.Error("failed to rollback transaction", "error", err)
+			result = "panic"
+			uow.rollbackTx(ctx, tx)
+			if hookErr := runRollbacks(ctx, append(state.rollbacks, atomicCtx.Rollbacks()...)); hookErr != nil {
+				uow.logger.Error("compensating rollback failed", "error", hookErr)
 			}
 			panic(r)
 		}
 	}()
 
-	txCtx, cancel := context.WithCancel(ctx)
+	txCtx, cancel := context.WithCancel(context.WithValue(ctx, txKey{}, state))
 	defer cancel()
 
-	if err := do(txCtx, atomicCtx); err != nil {
-		if err := tx.Rollback(); err != nil {
-			uow.logger.Error("failed to rollback transaction", "error", err)
+	doCtx, doSpan := uow.tracer.Start(txCtx, "unitofwork.do")
+	doErr := do(doCtx, atomicCtx)
+	doSpan.End()
+
+	if doErr != nil && !uow.isIgnored(doErr) {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", doErr.Error()))
+		uow.rollbackTx(ctx, tx)
+		return uow.rollbackError(ctx, append(state.rollbacks, atomicCtx.Rollbacks()...), doErr)
+	}
+
+	events := append(state.events, atomicCtx.CollectEvents()...)
+	span.SetAttributes(attribute.Int("event.count", len(events)))
+	if len(events) > 0 {
+		persistCtx, persistSpan := uow.tracer.Start(ctx, "unitofwork.PersistEvents")
+		err := uow.outboxStore(tx).Save(persistCtx, events...)
+		persistSpan.End()
+		if err != nil {
+			result = "rollback"
+			span.SetAttributes(attribute.String("rollback.reason", err.Error()))
+			uow.rollbackTx(ctx, tx)
+			uow.logger.Error("failed to persist events to outbox", "error", err)
+			return uow.rollbackError(ctx, append(state.rollbacks, atomicCtx.Rollbacks()...), err)
 		}
+	}
+
+	_, commitSpan := uow.tracer.Start(ctx, "unitofwork.Commit")
+	commitErr := atomicCtx.Commit()
+	commitSpan.End()
+	if commitErr != nil {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", commitErr.Error()))
+		uow.rollbackTx(ctx, tx)
+		return uow.rollbackError(ctx, append(state.rollbacks, atomicCtx.Rollbacks()...), commitErr)
+	}
+
+	// The transaction is durable now, so the outbox rows written above are
+	// visible to the OutboxDispatcher, which owns actual delivery to the
+	// message bus, including its own retry and dead-letter handling.
+	// UnitOfWork's part in "publishing" ends here, so there's no span for
+	// an operation it doesn't perform.
+	uow.metrics.recordEventsPublished(ctx, len(events))
+
+	return doErr
+}
+
+// rollbackTx rolls back tx, logging rather than returning any failure so
+// the caller can still report the original error that caused the rollback.
+func (uow *UnitOfWork[T]) rollbackTx(ctx context.Context, tx storage.DBContext) {
+	_, span := uow.tracer.Start(ctx, "unitofwork.Rollback")
+	defer span.End()
+	if err := tx.Rollback(); err != nil {
+		uow.logger.Error("failed to rollback transaction", "error", err)
+	}
+}
+
+// atomicNested runs do against the transaction already stored in state,
+// isolating it with a SAVEPOINT instead of a second storage.DB.Begin. It is
+// traced and measured the same way as runRoot, with "nested"=true and a
+// "depth" attribute standing in for "attempt", which doesn't apply here.
+func (uow *UnitOfWork[T]) atomicNested(
+	ctx context.Context,
+	state *txState,
+	do func(context.Context, T) error,
+) (err error) {
+	state.depth++
+	savepoint := fmt.Sprintf("uow_sp_%d", state.depth)
+
+	start := time.Now()
+	ctx, span := uow.tracer.Start(ctx, "unitofwork.Atomic", trace.WithAttributes(
+		attribute.Int("depth", state.depth),
+		attribute.Bool("nested", true),
+	))
+	result := "commit"
+	defer func() {
+		span.SetAttributes(attribute.String("result", result))
+		span.End()
+		uow.metrics.recordTransaction(ctx, result, true, time.Since(start))
+	}()
+
+	if err := state.tx.Savepoint(savepoint); err != nil {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", err.Error()))
 		return stateRollbackError(err)
 	}
 
-	if err := uow.msgBus.PublishEvents(atomicCtx.CollectEvents()...); err != nil {
-		uow.logger.Error("failed to publish events", "error", err)
-		return err
+	atomicCtx, err := uow.newContext(state.tx)
+	if err != nil {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", err.Error()))
+		return stateRollbackError(err)
 	}
 
-	return nil
+	defer func() {
+		if closeErr := atomicCtx.Close(); closeErr != nil {
+			uow.logger.Error("failed to close transaction context", "error", closeErr, "savepoint", savepoint)
+		}
+	}()
+
+	defer func() {
+		if r := recover(); r != nil {
+			result = "panic"
+			if err := state.tx.RollbackTo(savepoint); err != nil {
+				uow.logger.Error("failed to rollback to savepoint", "error", err, "savepoint", savepoint)
+			}
+			if hookErr := runRollbacks(ctx, atomicCtx.Rollbacks()); hookErr != nil {
+				uow.logger.Error("compensating rollback failed", "error", hookErr)
+			}
+			panic(r)
+		}
+	}()
+
+	eventsMark, rollbacksMark := len(state.events), len(state.rollbacks)
+
+	doCtx, doSpan := uow.tracer.Start(ctx, "unitofwork.do")
+	doErr := do(doCtx, atomicCtx)
+	doSpan.End()
+
+	if doErr != nil && !uow.isIgnored(doErr) {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", doErr.Error()))
+		if rbErr := state.tx.RollbackTo(savepoint); rbErr != nil {
+			uow.logger.Error("failed to rollback to savepoint", "error", rbErr, "savepoint", savepoint)
+		}
+		// Rolling back to this savepoint also undoes the work of calls nested
+		// inside it that released their own savepoints, so the hooks they
+		// merged into state run here and the events they merged are dropped.
+		hooks := append(append([]Rollbacker(nil), state.rollbacks[rollbacksMark:]...), atomicCtx.Rollbacks()...)
+		state.rollbacks = state.rollbacks[:rollbacksMark]
+		state.events = state.events[:eventsMark]
+		return uow.rollbackError(ctx, hooks, doErr)
+	}
+
+	if err := state.tx.Release(savepoint); err != nil {
+		result = "rollback"
+		span.SetAttributes(attribute.String("rollback.reason", err.Error()))
+		return stateRollbackError(err)
+	}
+
+	// An ignored error still releases the savepoint and merges in like a
+	// success, matching runRoot: the caller learns about it, but it isn't
+	// treated as a reason to undo this savepoint's work.
+	//
+	// The savepoint is released, not committed: the outermost transaction
+	// can still fail later and roll back everything, including this. Its
+	// events and rollback hooks are merged into state rather than acted on
+	// here, so the outermost call can persist/publish or compensate for
+	// them as if they were its own.
+	events := atomicCtx.CollectEvents()
+	span.SetAttributes(attribute.Int("event.count", len(events)))
+	state.events = append(state.events, events...)
+	state.rollbacks = append(state.rollbacks, atomicCtx.Rollbacks()...)
+	return doErr
 }
 
 func stateRollbackError(err error) error {
 	return errors.Join(fmt.Errorf("state rollback: %w", err), ErrRollback)
 }
+
+// rollbackError wraps cause the same way stateRollbackError does, additionally
+// joining in any errors from running hooks, which must already include every
+// compensating action registered via AtomicContext.OnRollback across the
+// whole chain of nested Atomic calls being rolled back, not just the
+// failing one.
+func (uow *UnitOfWork[T]) rollbackError(ctx context.Context, hooks []Rollbacker, cause error) error {
+	if hookErr := runRollbacks(ctx, hooks); hookErr != nil {
+		uow.logger.Error("compensating rollback failed", "error", hookErr)
+		return errors.Join(fmt.Errorf("state rollback: %w", cause), ErrRollback, hookErr)
+	}
+	return stateRollbackError(cause)
+}
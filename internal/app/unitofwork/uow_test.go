@@ -0,0 +1,348 @@
+package unitofwork
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"log/slog"
+	"reflect"
+	"testing"
+
+	"github.com/burenotti/go_health_backend/internal/adapter/storage"
+	"github.com/burenotti/go_health_backend/internal/domain"
+)
+
+// fakeResult is a no-op sql.Result for the ExecContext calls outboxStore
+// makes against a fakeTx.
+type fakeResult struct{}
+
+func (fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (fakeResult) RowsAffected() (int64, error) { return 0, nil }
+
+// fakeTx is an in-memory storage.DBContext that records what was called on
+// it instead of talking to a real database.
+type fakeTx struct {
+	rolledBack   bool
+	savepoints   []string
+	releasedTo   []string
+	rolledBackTo []string
+}
+
+func (t *fakeTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return fakeResult{}, nil
+}
+
+func (t *fakeTx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return nil, nil
+}
+
+func (t *fakeTx) Rollback() error {
+	t.rolledBack = true
+	return nil
+}
+
+func (t *fakeTx) Savepoint(name string) error {
+	t.savepoints = append(t.savepoints, name)
+	return nil
+}
+
+func (t *fakeTx) RollbackTo(name string) error {
+	t.rolledBackTo = append(t.rolledBackTo, name)
+	return nil
+}
+
+func (t *fakeTx) Release(name string) error {
+	t.releasedTo = append(t.releasedTo, name)
+	return nil
+}
+
+// fakeDB always hands out the same fakeTx, or beginErr if set.
+type fakeDB struct {
+	tx       *fakeTx
+	beginErr error
+}
+
+func (d *fakeDB) Begin(ctx context.Context) (storage.DBContext, error) {
+	if d.beginErr != nil {
+		return nil, d.beginErr
+	}
+	return d.tx, nil
+}
+
+// fakeAtomicContext is a minimal AtomicContext used to drive Atomic without
+// a real database or event bus.
+type fakeAtomicContext struct {
+	tx        storage.DBContext
+	commitErr error
+	closeErr  error
+	committed bool
+	closed    bool
+	events    []domain.Event
+	rollbacks []Rollbacker
+}
+
+func newFakeAtomicContext(tx storage.DBContext) (*fakeAtomicContext, error) {
+	return &fakeAtomicContext{tx: tx}, nil
+}
+
+func (c *fakeAtomicContext) Commit() error {
+	c.committed = true
+	return c.commitErr
+}
+
+func (c *fakeAtomicContext) Close() error {
+	c.closed = true
+	return c.closeErr
+}
+
+func (c *fakeAtomicContext) CollectEvents() []domain.Event { return c.events }
+
+func (c *fakeAtomicContext) addEvent(e domain.Event) { c.events = append(c.events, e) }
+
+func (c *fakeAtomicContext) OnRollback(r Rollbacker) { c.rollbacks = append(c.rollbacks, r) }
+
+func (c *fakeAtomicContext) Rollbacks() []Rollbacker { return c.rollbacks }
+
+// fakeEvent is a domain.Event that also satisfies outbox.EventSerializer,
+// so it can be persisted to the outbox by a real Atomic run.
+type fakeEvent struct{ eventType string }
+
+func (e fakeEvent) EventType() string          { return e.eventType }
+func (e fakeEvent) Serialize() ([]byte, error) { return []byte(e.eventType), nil }
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// transientErr satisfies the sqlStater interface isTransientError checks
+// for, so it can stand in for a pgx serialization failure / deadlock.
+type transientErr struct{ state string }
+
+func (e transientErr) Error() string    { return "transient: " + e.state }
+func (e transientErr) SQLState() string { return e.state }
+
+func TestAtomic_CommitsOnSuccess(t *testing.T) {
+	tx := &fakeTx{}
+	db := &fakeDB{tx: tx}
+	var gotCtx *fakeAtomicContext
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger())
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		gotCtx = ac
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !gotCtx.committed {
+		t.Error("expected Commit to be called")
+	}
+	if !gotCtx.closed {
+		t.Error("expected Close to be called")
+	}
+	if tx.rolledBack {
+		t.Error("did not expect a rollback")
+	}
+}
+
+func TestAtomic_RollsBackOnError(t *testing.T) {
+	tx := &fakeTx{}
+	db := &fakeDB{tx: tx}
+	sentinel := errors.New("boom")
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger())
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		return sentinel
+	})
+
+	if !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+	if !errors.Is(err, sentinel) {
+		t.Fatalf("expected error to wrap the original cause, got %v", err)
+	}
+	if !tx.rolledBack {
+		t.Error("expected a rollback")
+	}
+}
+
+func TestAtomic_RollsBackWhenCommitFails(t *testing.T) {
+	tx := &fakeTx{}
+	db := &fakeDB{tx: tx}
+	commitErr := errors.New("commit failed")
+	newCtx := func(tx storage.DBContext) (*fakeAtomicContext, error) {
+		return &fakeAtomicContext{tx: tx, commitErr: commitErr}, nil
+	}
+
+	uow := New[*fakeAtomicContext](db, newCtx, testLogger())
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		return nil
+	})
+
+	if !errors.Is(err, ErrRollback) {
+		t.Fatalf("expected ErrRollback, got %v", err)
+	}
+	if !errors.Is(err, commitErr) {
+		t.Fatalf("expected error to wrap the commit failure, got %v", err)
+	}
+	if !tx.rolledBack {
+		t.Error("expected a rollback after a failed commit")
+	}
+}
+
+func TestAtomic_IgnoredErrorCommitsAndReturnsOriginal(t *testing.T) {
+	tx := &fakeTx{}
+	db := &fakeDB{tx: tx}
+	sentinel := errors.New("ignored")
+	var gotCtx *fakeAtomicContext
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger(),
+		WithIgnoredErrors[*fakeAtomicContext](sentinel))
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		gotCtx = ac
+		return sentinel
+	})
+
+	if !errors.Is(err, sentinel) {
+		t.Fatalf("expected the original error back, got %v", err)
+	}
+	if errors.Is(err, ErrRollback) {
+		t.Fatalf("ignored error should not be reported as a rollback, got %v", err)
+	}
+	if !gotCtx.committed {
+		t.Error("expected the transaction to commit despite the ignored error")
+	}
+	if tx.rolledBack {
+		t.Error("did not expect a rollback")
+	}
+}
+
+func TestAtomic_RetriesTransientErrorsUntilSuccess(t *testing.T) {
+	db := &fakeDB{tx: &fakeTx{}}
+	attempts := 0
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger(),
+		WithRetry[*fakeAtomicContext](RetryPolicy{MaxAttempts: 3}))
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		attempts++
+		if attempts < 3 {
+			return transientErr{state: sqlStateSerializationFailure}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("expected eventual success, got %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts)
+	}
+}
+
+func TestAtomic_StopsRetryingAtMaxAttempts(t *testing.T) {
+	db := &fakeDB{tx: &fakeTx{}}
+	attempts := 0
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger(),
+		WithRetry[*fakeAtomicContext](RetryPolicy{MaxAttempts: 2}))
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		attempts++
+		return transientErr{state: sqlStateDeadlockDetected}
+	})
+
+	if err == nil {
+		t.Fatal("expected an error after exhausting retries")
+	}
+	if attempts != 2 {
+		t.Fatalf("expected exactly 2 attempts, got %d", attempts)
+	}
+}
+
+func TestAtomic_RollbackHooksRunInLIFOOrder(t *testing.T) {
+	db := &fakeDB{tx: &fakeTx{}}
+	var order []int
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger())
+	err := uow.Atomic(context.Background(), func(ctx context.Context, ac *fakeAtomicContext) error {
+		ac.OnRollback(RollbackFunc(func(ctx context.Context) error { order = append(order, 1); return nil }))
+		ac.OnRollback(RollbackFunc(func(ctx context.Context) error { order = append(order, 2); return nil }))
+		ac.OnRollback(RollbackFunc(func(ctx context.Context) error { order = append(order, 3); return nil }))
+		return errors.New("fail")
+	})
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("hooks ran in order %v, want %v", order, want)
+	}
+}
+
+func TestAtomic_NestedIgnoredErrorReleasesSavepointAndMerges(t *testing.T) {
+	tx := &fakeTx{}
+	db := &fakeDB{tx: tx}
+	sentinel := errors.New("ignored")
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger(),
+		WithIgnoredErrors[*fakeAtomicContext](sentinel))
+	err := uow.Atomic(context.Background(), func(ctx context.Context, outer *fakeAtomicContext) error {
+		nestedErr := uow.Atomic(ctx, func(ctx context.Context, inner *fakeAtomicContext) error {
+			inner.addEvent(fakeEvent{eventType: "inner.thing.happened"})
+			return sentinel
+		})
+		if !errors.Is(nestedErr, sentinel) {
+			t.Fatalf("expected the nested call to return the ignored error, got %v", nestedErr)
+		}
+		if errors.Is(nestedErr, ErrRollback) {
+			t.Fatalf("ignored error should not be reported as a rollback, got %v", nestedErr)
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tx.rolledBackTo) != 0 {
+		t.Errorf("expected no savepoint rollback, got %v", tx.rolledBackTo)
+	}
+	if len(tx.releasedTo) != 1 {
+		t.Errorf("expected the savepoint to be released once, got %v", tx.releasedTo)
+	}
+}
+
+// TestAtomic_NestedEventsAndRollbacksMergeIntoOuter covers the scenario a
+// savepoint doesn't protect against: a nested Atomic call commits its
+// savepoint successfully, but the outer transaction later fails and does a
+// full ROLLBACK, which undoes the nested call's DB work regardless. The
+// nested call's event and rollback hook are both merged into the outermost
+// call; the test checks the observable half, that the merged hook runs when
+// the outermost call rolls back (the merged event is discarded with it).
+func TestAtomic_NestedEventsAndRollbacksMergeIntoOuter(t *testing.T) {
+	db := &fakeDB{tx: &fakeTx{}}
+	var ranHook bool
+
+	uow := New[*fakeAtomicContext](db, newFakeAtomicContext, testLogger())
+	err := uow.Atomic(context.Background(), func(ctx context.Context, outer *fakeAtomicContext) error {
+		nestedErr := uow.Atomic(ctx, func(ctx context.Context, inner *fakeAtomicContext) error {
+			inner.addEvent(fakeEvent{eventType: "inner.thing.happened"})
+			inner.OnRollback(RollbackFunc(func(ctx context.Context) error {
+				ranHook = true
+				return nil
+			}))
+			return nil
+		})
+		if nestedErr != nil {
+			t.Fatalf("nested Atomic failed: %v", nestedErr)
+		}
+		return errors.New("outer failure forces a full rollback")
+	})
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if !ranHook {
+		t.Error("expected the nested call's rollback hook to run once the outer transaction rolled back")
+	}
+}